@@ -1,9 +1,13 @@
+//go:build !scanner
 // +build !scanner
 
 package detector
 
 import (
+	"context"
+	"fmt"
 	"os"
+	"sync"
 
 	"github.com/future-architect/vuls/config"
 	"github.com/future-architect/vuls/logging"
@@ -15,6 +19,122 @@ import (
 	"golang.org/x/xerrors"
 )
 
+// CGO-free (CGO_ENABLED=0) builds of this package are not possible: each of
+// cvedb/ovaldb/gostdb/exploitdb/metasploitdb blank-imports mattn/go-sqlite3,
+// which is linked in statically regardless of which driver name is
+// registered under "sqlite3". Delivering a CGO-free build needs each of
+// those five upstream packages to offer (or be forked to use) a CGO-free
+// SQLite driver; nothing in this package can substitute one underneath them.
+
+// dbCloser is implemented by every vulnerability DB driver this package opens.
+type dbCloser interface {
+	CloseDB() error
+}
+
+// dbRegistryKey identifies a driver shared across DBClient instances in this
+// process: the DB kind ("cve", "oval", "gost", "exploit", "metasploit") plus
+// the path/DSN it was opened with.
+type dbRegistryKey struct {
+	kind string
+	path string
+}
+
+type dbRegistryEntry struct {
+	refCount int
+	driver   dbCloser
+}
+
+// dbRegistryMu guards dbRegistry and is held for the entire duration of
+// acquireDB/releaseDB/forceCloseDB, including the open()/CloseDB() calls
+// they make. A narrower scheme that dropped dbRegistryMu while acquiring a
+// per-entry lock left a window where a concurrent release/forceCloseDB
+// could delete and close the entry before acquireDB got to it, resurrecting
+// a driver onto an orphaned entry no other caller could reach - reopening
+// the exact "database is locked" race this registry exists to prevent.
+// Holding one lock for the whole section serializes DB opens/closes across
+// all kind/path pairs, but those only happen at startup or Reopen, not on
+// any hot path, so the tradeoff is worth the correctness.
+var (
+	dbRegistryMu sync.Mutex
+	dbRegistry   = map[dbRegistryKey]*dbRegistryEntry{}
+)
+
+// acquireDB opens (or reuses) the driver registered for kind/path. Concurrent
+// DBClient instances that ask for the same kind/path share one underlying
+// *sql.DB, which avoids "database is locked" races between independently
+// opened SQLite handles on the same file.
+func acquireDB[T dbCloser](kind, path string, open func() (T, bool, error)) (T, bool, error) {
+	key := dbRegistryKey{kind: kind, path: path}
+
+	dbRegistryMu.Lock()
+	defer dbRegistryMu.Unlock()
+
+	if entry, ok := dbRegistry[key]; ok {
+		entry.refCount++
+		return entry.driver.(T), false, nil
+	}
+
+	driver, locked, err := open()
+	if err != nil || locked {
+		var zero T
+		return zero, locked, err
+	}
+	dbRegistry[key] = &dbRegistryEntry{refCount: 1, driver: driver}
+	return driver, false, nil
+}
+
+// releaseDB drops one reference to the driver registered for kind/path,
+// closing and evicting it once the last reference is released.
+func releaseDB(kind, path string) error {
+	key := dbRegistryKey{kind: kind, path: path}
+
+	dbRegistryMu.Lock()
+	defer dbRegistryMu.Unlock()
+
+	entry, ok := dbRegistry[key]
+	if !ok {
+		return nil
+	}
+	entry.refCount--
+	if entry.refCount > 0 {
+		return nil
+	}
+
+	delete(dbRegistry, key)
+	return entry.driver.CloseDB()
+}
+
+// forceCloseDB closes and evicts the driver registered for kind/path
+// regardless of how many DBClients still reference it, returning the
+// refCount it had just before eviction. Unlike releaseDB, this is not a
+// reference decrement: any other DBClient still holding this driver is left
+// with a closed handle and must call Reopen itself to get a working one
+// again. Reopen uses this instead of releaseDB precisely because a plain
+// decrement would silently no-op whenever another DBClient shares the
+// kind/path, leaving the caller under the impression it reopened something.
+func forceCloseDB(kind, path string) (priorRefCount int, err error) {
+	key := dbRegistryKey{kind: kind, path: path}
+
+	dbRegistryMu.Lock()
+	defer dbRegistryMu.Unlock()
+
+	entry, ok := dbRegistry[key]
+	if !ok {
+		return 0, nil
+	}
+	delete(dbRegistry, key)
+	return entry.refCount, entry.driver.CloseDB()
+}
+
+// dbPath resolves the path/DSN a New*DB function opened typ with, matching
+// the SQLite3Path-vs-URL precedence each of them applies.
+func dbPath(typ, url, sqlite3Path string) string {
+	if typ == "sqlite3" {
+		return sqlite3Path
+	}
+	return url
+}
+
 // DBClient is DB client for reporting
 type DBClient struct {
 	CveDB        cvedb.DB
@@ -22,6 +142,9 @@ type DBClient struct {
 	GostDB       gostdb.DB
 	ExploitDB    exploitdb.DB
 	MetasploitDB metasploitdb.DB
+
+	cnf DBClientConf
+	ctx context.Context
 }
 
 // DBClientConf has a configuration of Vulnerability DBs
@@ -34,8 +157,24 @@ type DBClientConf struct {
 	DebugSQL      bool
 }
 
+// rollbackAcquiredRefs releases every ref in refs, logging rather than
+// failing the caller if one of them doesn't release cleanly. Used by
+// NewDBClient to undo the refs it already acquired when a later DB in the
+// same call turns out to be locked: those earlier refs aren't returned to
+// anyone, so without this they'd be stuck at refCount>=1 forever.
+func rollbackAcquiredRefs(refs []dbRef) {
+	for _, ref := range refs {
+		if cerr := releaseDB(ref.kind, ref.path); cerr != nil {
+			logging.Log.Warnf("Failed to release %sDB while rolling back a partially-opened DBClient: %+v", ref.kind, cerr)
+		}
+	}
+}
+
 // NewDBClient returns db clients
 func NewDBClient(cnf DBClientConf) (dbclient *DBClient, locked bool, err error) {
+	var opened []dbRef
+	rollback := func() { rollbackAcquiredRefs(opened) }
+
 	cveDriver, locked, err := NewCveDB(cnf)
 	if locked {
 		return nil, true, xerrors.Errorf("CveDB is locked: %s",
@@ -43,36 +182,49 @@ func NewDBClient(cnf DBClientConf) (dbclient *DBClient, locked bool, err error)
 	} else if err != nil {
 		return nil, locked, err
 	}
+	if cveDriver != nil {
+		opened = append(opened, dbRef{"cve", cveDBSource{cnf.CveDictCnf}.DSN()})
+	}
 
 	ovaldb, locked, err := NewOvalDB(cnf)
 	if locked {
+		rollback()
 		return nil, true, xerrors.Errorf("OvalDB is locked: %s",
 			cnf.OvalDictCnf.SQLite3Path)
 	} else if err != nil {
 		logging.Log.Warnf("Unable to use OvalDB: %s, err: %+v",
 			cnf.OvalDictCnf.SQLite3Path, err)
+	} else if ovaldb != nil {
+		opened = append(opened, dbRef{"oval", ovalDBSource{cnf.OvalDictCnf}.DSN()})
 	}
 
 	gostdb, locked, err := NewGostDB(cnf)
 	if locked {
+		rollback()
 		return nil, true, xerrors.Errorf("gostDB is locked: %s",
 			cnf.GostCnf.SQLite3Path)
 	} else if err != nil {
 		logging.Log.Warnf("Unable to use gostDB: %s, err: %+v",
 			cnf.GostCnf.SQLite3Path, err)
+	} else if gostdb != nil {
+		opened = append(opened, dbRef{"gost", gostDBSource{cnf.GostCnf}.DSN()})
 	}
 
 	exploitdb, locked, err := NewExploitDB(cnf)
 	if locked {
+		rollback()
 		return nil, true, xerrors.Errorf("exploitDB is locked: %s",
 			cnf.ExploitCnf.SQLite3Path)
 	} else if err != nil {
 		logging.Log.Warnf("Unable to use exploitDB: %s, err: %+v",
 			cnf.ExploitCnf.SQLite3Path, err)
+	} else if exploitdb != nil {
+		opened = append(opened, dbRef{"exploit", exploitDBSource{cnf.ExploitCnf}.DSN()})
 	}
 
 	metasploitdb, locked, err := NewMetasploitDB(cnf)
 	if locked {
+		rollback()
 		return nil, true, xerrors.Errorf("metasploitDB is locked: %s",
 			cnf.MetasploitCnf.SQLite3Path)
 	} else if err != nil {
@@ -86,50 +238,112 @@ func NewDBClient(cnf DBClientConf) (dbclient *DBClient, locked bool, err error)
 		GostDB:       gostdb,
 		ExploitDB:    exploitdb,
 		MetasploitDB: metasploitdb,
+		cnf:          cnf,
 	}, false, nil
 }
 
-// NewCveDB returns cve db client
-func NewCveDB(cnf DBClientConf) (driver cvedb.DB, locked bool, err error) {
-	if cnf.CveDictCnf.IsFetchViaHTTP() {
-		return nil, false, nil
-	}
-	logging.Log.Debugf("open cve-dictionary db (%s)", cnf.CveDictCnf.Type)
-	path := cnf.CveDictCnf.URL
-	if cnf.CveDictCnf.Type == "sqlite3" {
-		path = cnf.CveDictCnf.SQLite3Path
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			logging.Log.Warnf("--cvedb-path=%s file not found. [CPE-scan](https://vuls.io/docs/en/usage-scan-non-os-packages.html#cpe-scan) needs cve-dictionary. if you specify cpe in config.toml, fetch cve-dictionary before reporting. For details, see `https://github.com/kotakanbe/go-cve-dictionary#deploy-go-cve-dictionary`", path)
-			return nil, false, nil
+// localFileDBTypes are the backends whose DSN is a path on local disk that
+// has to exist before NewDB is called, as opposed to a DSN/URL for a remote
+// server (mysql, postgres, redis) which NewDB itself dials out to.
+var localFileDBTypes = map[string]bool{
+	"sqlite3": true,
+	"boltdb":  true,
+}
+
+// VulnDBSource is the config-side contract openVulnDB needs to open one of
+// the five vulnerability DBs without repeating the same path-resolution,
+// driver-selection, lock-handling and warning-message dance five times. Each
+// New*DB function below adapts its own DBClientConf field (and its embedded
+// config.VulnDBBackend) to this interface.
+type VulnDBSource interface {
+	// Kind is the registry key ("cve", "oval", "gost", "exploit", "metasploit").
+	Kind() string
+	// DBType is the backend driver name: "sqlite3", "mysql", "postgres", "redis", "boltdb", ...
+	DBType() string
+	// DSN is the resolved path (for sqlite3/boltdb) or connection URL to open.
+	DSN() string
+	// NotFoundWarning is logged when a local sqlite3/boltdb DSN doesn't exist
+	// on disk, or when a remote backend's DSN is left empty.
+	NotFoundWarning() string
+	IsFetchViaHTTP() bool
+
+	// VulnDBSource intentionally has no MaxOpenConns/MaxIdleConns/RedisTimeout
+	// accessors: none of cvedb.DB/ovaldb.DB/gostdb.DB/exploitdb.DB/metasploitdb.DB
+	// expose a pool/timeout knob openVulnDB could apply after opening, so
+	// connection-pool size and the redis command timeout are not wired through
+	// this package. Config fields by those names, if any of the five
+	// DBClientConf members still carry them, have no effect here.
+}
+
+// openVulnDB implements the open-or-skip-or-fail path shared by NewCveDB,
+// NewOvalDB, NewGostDB, NewExploitDB and NewMetasploitDB: skip when the
+// caller will fetch via HTTP instead, skip with a warning when a local
+// sqlite3/boltdb file is missing or a remote DSN is empty, then open (or
+// reuse, via acquireDB) the underlying driver.
+func openVulnDB[T dbCloser](src VulnDBSource, open func(dbType, dsn string) (T, bool, error)) (driver T, locked bool, err error) {
+	if src.IsFetchViaHTTP() {
+		return driver, false, nil
+	}
+
+	dbType, dsn := src.DBType(), src.DSN()
+	if localFileDBTypes[dbType] {
+		if _, err := os.Stat(dsn); os.IsNotExist(err) {
+			logging.Log.Warnf("%s", src.NotFoundWarning())
+			return driver, false, nil
 		}
+	} else if dsn == "" {
+		logging.Log.Warnf("%s", src.NotFoundWarning())
+		return driver, false, nil
 	}
 
-	logging.Log.Debugf("Open cve-dictionary db (%s): %s", cnf.CveDictCnf.Type, path)
-	driver, locked, err = cvedb.NewDB(cnf.CveDictCnf.Type, path, cnf.DebugSQL)
-	if err != nil {
-		err = xerrors.Errorf("Failed to init CVE DB. err: %w, path: %s", err, path)
-		return nil, locked, err
+	logging.Log.Debugf("Open %s db (%s): %s", src.Kind(), dbType, dsn)
+	driver, locked, err = acquireDB(src.Kind(), dsn, func() (T, bool, error) {
+		return open(dbType, dsn)
+	})
+	if err != nil || locked {
+		return driver, locked, err
 	}
 	return driver, false, nil
 }
 
-// NewOvalDB returns oval db client
-func NewOvalDB(cnf DBClientConf) (driver ovaldb.DB, locked bool, err error) {
-	if cnf.OvalDictCnf.IsFetchViaHTTP() {
-		return nil, false, nil
-	}
-	path := cnf.OvalDictCnf.URL
-	if cnf.OvalDictCnf.Type == "sqlite3" {
-		path = cnf.OvalDictCnf.SQLite3Path
+// cveDBSource adapts config.GoCveDictConf to VulnDBSource.
+type cveDBSource struct{ cnf config.GoCveDictConf }
 
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			logging.Log.Warnf("--ovaldb-path=%s file not found", path)
-			return nil, false, nil
-		}
+func (s cveDBSource) Kind() string         { return "cve" }
+func (s cveDBSource) DBType() string       { return s.cnf.Type }
+func (s cveDBSource) DSN() string          { return dbPath(s.cnf.Type, s.cnf.URL, s.cnf.SQLite3Path) }
+func (s cveDBSource) IsFetchViaHTTP() bool { return s.cnf.IsFetchViaHTTP() }
+func (s cveDBSource) NotFoundWarning() string {
+	return fmt.Sprintf("--cvedb-path=%s file not found. [CPE-scan](https://vuls.io/docs/en/usage-scan-non-os-packages.html#cpe-scan) needs cve-dictionary. if you specify cpe in config.toml, fetch cve-dictionary before reporting. For details, see `https://github.com/kotakanbe/go-cve-dictionary#deploy-go-cve-dictionary`", s.DSN())
+}
+
+// NewCveDB returns cve db client
+func NewCveDB(cnf DBClientConf) (driver cvedb.DB, locked bool, err error) {
+	driver, locked, err = openVulnDB(cveDBSource{cnf.CveDictCnf}, func(dbType, dsn string) (cvedb.DB, bool, error) {
+		return cvedb.NewDB(dbType, dsn, cnf.DebugSQL)
+	})
+	if err != nil {
+		return nil, locked, xerrors.Errorf("Failed to init CVE DB. err: %w, path: %s", err, cveDBSource{cnf.CveDictCnf}.DSN())
 	}
+	return driver, false, nil
+}
 
-	logging.Log.Debugf("Open oval-dictionary db (%s): %s", cnf.OvalDictCnf.Type, path)
-	driver, locked, err = ovaldb.NewDB("", cnf.OvalDictCnf.Type, path, cnf.DebugSQL)
+// ovalDBSource adapts config.GovalDictConf to VulnDBSource.
+type ovalDBSource struct{ cnf config.GovalDictConf }
+
+func (s ovalDBSource) Kind() string         { return "oval" }
+func (s ovalDBSource) DBType() string       { return s.cnf.Type }
+func (s ovalDBSource) DSN() string          { return dbPath(s.cnf.Type, s.cnf.URL, s.cnf.SQLite3Path) }
+func (s ovalDBSource) IsFetchViaHTTP() bool { return s.cnf.IsFetchViaHTTP() }
+func (s ovalDBSource) NotFoundWarning() string {
+	return fmt.Sprintf("--ovaldb-path=%s file not found", s.DSN())
+}
+
+// NewOvalDB returns oval db client
+func NewOvalDB(cnf DBClientConf) (driver ovaldb.DB, locked bool, err error) {
+	driver, locked, err = openVulnDB(ovalDBSource{cnf.OvalDictCnf}, func(dbType, dsn string) (ovaldb.DB, bool, error) {
+		return ovaldb.NewDB("", dbType, dsn, cnf.DebugSQL)
+	})
 	if err != nil {
 		err = xerrors.Errorf("Failed to new OVAL DB. err: %w", err)
 		if locked {
@@ -140,23 +354,22 @@ func NewOvalDB(cnf DBClientConf) (driver ovaldb.DB, locked bool, err error) {
 	return driver, false, nil
 }
 
-// NewGostDB returns db client for Gost
-func NewGostDB(cnf DBClientConf) (driver gostdb.DB, locked bool, err error) {
-	if cnf.GostCnf.IsFetchViaHTTP() {
-		return nil, false, nil
-	}
-	path := cnf.GostCnf.URL
-	if cnf.GostCnf.Type == "sqlite3" {
-		path = cnf.GostCnf.SQLite3Path
+// gostDBSource adapts config.GostConf to VulnDBSource.
+type gostDBSource struct{ cnf config.GostConf }
 
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			logging.Log.Warnf("--gostdb-path=%s file not found. Vuls can detect `patch-not-released-CVE-ID` using gost if the scan target server is Debian, RHEL or CentOS, For details, see `https://github.com/knqyf263/gost#fetch-redhat`", path)
-			return nil, false, nil
-		}
-	}
+func (s gostDBSource) Kind() string         { return "gost" }
+func (s gostDBSource) DBType() string       { return s.cnf.Type }
+func (s gostDBSource) DSN() string          { return dbPath(s.cnf.Type, s.cnf.URL, s.cnf.SQLite3Path) }
+func (s gostDBSource) IsFetchViaHTTP() bool { return s.cnf.IsFetchViaHTTP() }
+func (s gostDBSource) NotFoundWarning() string {
+	return fmt.Sprintf("--gostdb-path=%s file not found. Vuls can detect `patch-not-released-CVE-ID` using gost if the scan target server is Debian, RHEL or CentOS, For details, see `https://github.com/knqyf263/gost#fetch-redhat`", s.DSN())
+}
 
-	logging.Log.Debugf("Open gost db (%s): %s", cnf.GostCnf.Type, path)
-	if driver, locked, err = gostdb.NewDB(cnf.GostCnf.Type, path, cnf.DebugSQL); err != nil {
+// NewGostDB returns db client for Gost
+func NewGostDB(cnf DBClientConf) (driver gostdb.DB, locked bool, err error) {
+	if driver, locked, err = openVulnDB(gostDBSource{cnf.GostCnf}, func(dbType, dsn string) (gostdb.DB, bool, error) {
+		return gostdb.NewDB(dbType, dsn, cnf.DebugSQL)
+	}); err != nil {
 		if locked {
 			return nil, true, xerrors.Errorf("gostDB is locked. err: %w", err)
 		}
@@ -165,23 +378,22 @@ func NewGostDB(cnf DBClientConf) (driver gostdb.DB, locked bool, err error) {
 	return driver, false, nil
 }
 
-// NewExploitDB returns db client for Exploit
-func NewExploitDB(cnf DBClientConf) (driver exploitdb.DB, locked bool, err error) {
-	if cnf.ExploitCnf.IsFetchViaHTTP() {
-		return nil, false, nil
-	}
-	path := cnf.ExploitCnf.URL
-	if cnf.ExploitCnf.Type == "sqlite3" {
-		path = cnf.ExploitCnf.SQLite3Path
+// exploitDBSource adapts config.ExploitConf to VulnDBSource.
+type exploitDBSource struct{ cnf config.ExploitConf }
 
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			logging.Log.Warnf("--exploitdb-path=%s file not found. Fetch go-exploit-db before reporting if you want to display exploit codes of detected CVE-IDs. For details, see `https://github.com/vulsio/go-exploitdb`", path)
-			return nil, false, nil
-		}
-	}
+func (s exploitDBSource) Kind() string         { return "exploit" }
+func (s exploitDBSource) DBType() string       { return s.cnf.Type }
+func (s exploitDBSource) DSN() string          { return dbPath(s.cnf.Type, s.cnf.URL, s.cnf.SQLite3Path) }
+func (s exploitDBSource) IsFetchViaHTTP() bool { return s.cnf.IsFetchViaHTTP() }
+func (s exploitDBSource) NotFoundWarning() string {
+	return fmt.Sprintf("--exploitdb-path=%s file not found. Fetch go-exploit-db before reporting if you want to display exploit codes of detected CVE-IDs. For details, see `https://github.com/vulsio/go-exploitdb`", s.DSN())
+}
 
-	logging.Log.Debugf("Open exploit db (%s): %s", cnf.ExploitCnf.Type, path)
-	if driver, locked, err = exploitdb.NewDB(cnf.ExploitCnf.Type, path, cnf.DebugSQL); err != nil {
+// NewExploitDB returns db client for Exploit
+func NewExploitDB(cnf DBClientConf) (driver exploitdb.DB, locked bool, err error) {
+	if driver, locked, err = openVulnDB(exploitDBSource{cnf.ExploitCnf}, func(dbType, dsn string) (exploitdb.DB, bool, error) {
+		return exploitdb.NewDB(dbType, dsn, cnf.DebugSQL)
+	}); err != nil {
 		if locked {
 			return nil, true, xerrors.Errorf("exploitDB is locked. err: %w", err)
 		}
@@ -190,23 +402,22 @@ func NewExploitDB(cnf DBClientConf) (driver exploitdb.DB, locked bool, err error
 	return driver, false, nil
 }
 
-// NewMetasploitDB returns db client for Metasploit
-func NewMetasploitDB(cnf DBClientConf) (driver metasploitdb.DB, locked bool, err error) {
-	if cnf.MetasploitCnf.IsFetchViaHTTP() {
-		return nil, false, nil
-	}
-	path := cnf.MetasploitCnf.URL
-	if cnf.MetasploitCnf.Type == "sqlite3" {
-		path = cnf.MetasploitCnf.SQLite3Path
+// metasploitDBSource adapts config.MetasploitConf to VulnDBSource.
+type metasploitDBSource struct{ cnf config.MetasploitConf }
 
-		if _, err := os.Stat(path); os.IsNotExist(err) {
-			logging.Log.Warnf("--msfdb-path=%s file not found. Fetch go-msfdb before reporting if you want to display metasploit modules of detected CVE-IDs. For details, see `https://github.com/takuzoo3868/go-msfdb`", path)
-			return nil, false, nil
-		}
-	}
+func (s metasploitDBSource) Kind() string         { return "metasploit" }
+func (s metasploitDBSource) DBType() string       { return s.cnf.Type }
+func (s metasploitDBSource) DSN() string          { return dbPath(s.cnf.Type, s.cnf.URL, s.cnf.SQLite3Path) }
+func (s metasploitDBSource) IsFetchViaHTTP() bool { return s.cnf.IsFetchViaHTTP() }
+func (s metasploitDBSource) NotFoundWarning() string {
+	return fmt.Sprintf("--msfdb-path=%s file not found. Fetch go-msfdb before reporting if you want to display metasploit modules of detected CVE-IDs. For details, see `https://github.com/takuzoo3868/go-msfdb`", s.DSN())
+}
 
-	logging.Log.Debugf("Open metasploit db (%s): %s", cnf.MetasploitCnf.Type, path)
-	if driver, locked, err = metasploitdb.NewDB(cnf.MetasploitCnf.Type, path, cnf.DebugSQL, false); err != nil {
+// NewMetasploitDB returns db client for Metasploit
+func NewMetasploitDB(cnf DBClientConf) (driver metasploitdb.DB, locked bool, err error) {
+	if driver, locked, err = openVulnDB(metasploitDBSource{cnf.MetasploitCnf}, func(dbType, dsn string) (metasploitdb.DB, bool, error) {
+		return metasploitdb.NewDB(dbType, dsn, cnf.DebugSQL, false)
+	}); err != nil {
 		if locked {
 			return nil, true, xerrors.Errorf("metasploitDB is locked. err: %w", err)
 		}
@@ -215,18 +426,110 @@ func NewMetasploitDB(cnf DBClientConf) (driver metasploitdb.DB, locked bool, err
 	return driver, false, nil
 }
 
-// CloseDB close dbs
-func (d DBClient) CloseDB() (errs []error) {
+// dbRef identifies one of DBClient's five vulnerability DBs by its registry
+// kind and the path/DSN it was opened with.
+type dbRef struct {
+	kind string
+	path string
+}
+
+// dbRefs lists the registry kind/path for each of d's non-nil vulnerability
+// DB handles, shared by CloseDB and Reopen so they don't repeat the same
+// five-tuple dance.
+func (d DBClient) dbRefs() (refs []dbRef) {
 	if d.CveDB != nil {
-		if err := d.CveDB.CloseDB(); err != nil {
-			errs = append(errs, xerrors.Errorf("Failed to close cveDB. err: %+v", err))
-		}
+		refs = append(refs, dbRef{"cve", dbPath(d.cnf.CveDictCnf.Type, d.cnf.CveDictCnf.URL, d.cnf.CveDictCnf.SQLite3Path)})
 	}
 	if d.OvalDB != nil {
-		if err := d.OvalDB.CloseDB(); err != nil {
-			errs = append(errs, xerrors.Errorf("Failed to close ovalDB. err: %+v", err))
+		refs = append(refs, dbRef{"oval", dbPath(d.cnf.OvalDictCnf.Type, d.cnf.OvalDictCnf.URL, d.cnf.OvalDictCnf.SQLite3Path)})
+	}
+	if d.GostDB != nil {
+		refs = append(refs, dbRef{"gost", dbPath(d.cnf.GostCnf.Type, d.cnf.GostCnf.URL, d.cnf.GostCnf.SQLite3Path)})
+	}
+	if d.ExploitDB != nil {
+		refs = append(refs, dbRef{"exploit", dbPath(d.cnf.ExploitCnf.Type, d.cnf.ExploitCnf.URL, d.cnf.ExploitCnf.SQLite3Path)})
+	}
+	if d.MetasploitDB != nil {
+		refs = append(refs, dbRef{"metasploit", dbPath(d.cnf.MetasploitCnf.Type, d.cnf.MetasploitCnf.URL, d.cnf.MetasploitCnf.SQLite3Path)})
+	}
+	return refs
+}
+
+// CloseDB releases this DBClient's reference to each of the five vulnerability
+// DBs it holds, via the shared registry, closing the underlying driver once no
+// other DBClient in this process still references it.
+func (d DBClient) CloseDB() (errs []error) {
+	for _, ref := range d.dbRefs() {
+		if err := releaseDB(ref.kind, ref.path); err != nil {
+			errs = append(errs, xerrors.Errorf("Failed to close %sDB. err: %+v", ref.kind, err))
 		}
 	}
-	//TODO CloseDB gost, exploitdb, metasploit
 	return errs
 }
+
+// WithContext attaches ctx to d for use by a subsequent Reopen call. Reopen
+// only checks ctx before it starts; forceCloseDB and NewDBClient take no
+// context themselves, so a cancellation that arrives once Reopen is already
+// closing/reopening DBs is not observed until the next call.
+func (d *DBClient) WithContext(ctx context.Context) *DBClient {
+	d.ctx = ctx
+	return d
+}
+
+// Reopen closes d's current vulnerability DB handles and re-derives fresh
+// ones from the same DBClientConf. A long-running process (e.g. `vuls
+// server`) can call this, via SIGHUP or an HTTP admin endpoint, to pick up
+// SQLite files that go-cve-dictionary/goval-dictionary/etc. just re-fetched,
+// without restarting. The returned locked/err mirror NewDBClient's.
+//
+// Unlike CloseDB, Reopen evicts each DB from the shared registry
+// unconditionally instead of decrementing a refcount: if it only released its
+// own reference, a kind/path still referenced by another DBClient in this
+// process would never actually close, and Reopen would silently no-op
+// instead of picking up the freshly fetched file. The cost is that any other
+// DBClient sharing one of these kind/paths is left holding a closed handle
+// the moment this returns; it must call Reopen itself (or be restarted)
+// before using that DB again. Warn here since that other holder has no way
+// to know its handle just went stale out from under it.
+//
+// If NewDBClient fails or reports locked partway through, *d is left
+// zeroed rather than untouched: d's old handles are already closed by the
+// forceCloseDB loop above, and NewDBClient itself rolls back whatever refs
+// it newly acquired before reporting the failure, so there's nothing valid
+// left for d to point at. Zeroing d.dbRefs() to empty means a subsequent
+// CloseDB or Reopen on this same DBClient is a no-op instead of operating
+// on stale kind/paths that may since have been legitimately reacquired by
+// someone else.
+//
+// The ctx passed to WithContext is only checked here, before any DB is
+// touched; once the forceCloseDB/NewDBClient calls below start, there's no
+// way to interrupt them; neither takes a context.
+func (d *DBClient) Reopen() (locked bool, err error) {
+	ctx := d.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := ctx.Err(); err != nil {
+		return false, xerrors.Errorf("Reopen canceled before start: %w", err)
+	}
+
+	for _, ref := range d.dbRefs() {
+		priorRefCount, cerr := forceCloseDB(ref.kind, ref.path)
+		if cerr != nil {
+			logging.Log.Warnf("Failed to close %sDB before reopen: %+v", ref.kind, cerr)
+		}
+		if priorRefCount > 1 {
+			logging.Log.Warnf("Reopening %s db (%s): %d other DBClient(s) in this process were still referencing it; their handles are now stale until they Reopen too", ref.kind, ref.path, priorRefCount-1)
+		}
+	}
+
+	reopened, locked, err := NewDBClient(d.cnf)
+	if err != nil || locked {
+		*d = DBClient{cnf: d.cnf, ctx: ctx}
+		return locked, err
+	}
+
+	*d = *reopened
+	d.ctx = ctx
+	return false, nil
+}
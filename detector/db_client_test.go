@@ -0,0 +1,191 @@
+//go:build !scanner
+// +build !scanner
+
+package detector
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+)
+
+// fakeDBDriver is a minimal dbCloser used to exercise the registry without
+// touching any real vulnerability DB.
+type fakeDBDriver struct {
+	mu     sync.Mutex
+	closed bool
+}
+
+func (f *fakeDBDriver) CloseDB() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return fmt.Errorf("already closed")
+	}
+	f.closed = true
+	return nil
+}
+
+func TestAcquireReleaseDB_SharesOneDriverPerKindPath(t *testing.T) {
+	kind, path := "test-acquire-release", t.Name()
+	opens := 0
+	open := func() (*fakeDBDriver, bool, error) {
+		opens++
+		return &fakeDBDriver{}, false, nil
+	}
+
+	d1, locked, err := acquireDB(kind, path, open)
+	if err != nil || locked {
+		t.Fatalf("acquireDB #1: locked=%v err=%v", locked, err)
+	}
+	d2, locked, err := acquireDB(kind, path, open)
+	if err != nil || locked {
+		t.Fatalf("acquireDB #2: locked=%v err=%v", locked, err)
+	}
+	if d1 != d2 {
+		t.Fatalf("acquireDB returned distinct drivers for the same kind/path")
+	}
+	if opens != 1 {
+		t.Fatalf("open() called %d times, want 1 (second acquire should reuse)", opens)
+	}
+
+	if err := releaseDB(kind, path); err != nil {
+		t.Fatalf("releaseDB #1: %v", err)
+	}
+	if d1.closed {
+		t.Fatalf("driver closed after releasing only one of two references")
+	}
+	if err := releaseDB(kind, path); err != nil {
+		t.Fatalf("releaseDB #2: %v", err)
+	}
+	if !d1.closed {
+		t.Fatalf("driver not closed after releasing the last reference")
+	}
+}
+
+func TestForceCloseDB_EvictsRegardlessOfRefCount(t *testing.T) {
+	kind, path := "test-force-close", t.Name()
+	open := func() (*fakeDBDriver, bool, error) { return &fakeDBDriver{}, false, nil }
+
+	d, _, err := acquireDB(kind, path, open)
+	if err != nil {
+		t.Fatalf("acquireDB: %v", err)
+	}
+	if _, err := acquireDB(kind, path, open); err != nil {
+		t.Fatalf("second acquireDB: %v", err)
+	}
+
+	priorRefCount, err := forceCloseDB(kind, path)
+	if err != nil {
+		t.Fatalf("forceCloseDB: %v", err)
+	}
+	if priorRefCount != 2 {
+		t.Fatalf("priorRefCount = %d, want 2", priorRefCount)
+	}
+	if !d.closed {
+		t.Fatalf("forceCloseDB did not close the driver")
+	}
+
+	// The registry entry should be gone: a fresh acquire opens a new driver.
+	opens := 0
+	d2, _, err := acquireDB(kind, path, func() (*fakeDBDriver, bool, error) {
+		opens++
+		return &fakeDBDriver{}, false, nil
+	})
+	if err != nil {
+		t.Fatalf("acquireDB after forceCloseDB: %v", err)
+	}
+	if opens != 1 || d2 == d {
+		t.Fatalf("forceCloseDB left the evicted entry reachable")
+	}
+	if err := releaseDB(kind, path); err != nil {
+		t.Fatalf("cleanup releaseDB: %v", err)
+	}
+}
+
+// TestDBRegistry_ConcurrentAcquireReleaseForceClose exercises acquireDB,
+// releaseDB and forceCloseDB from many goroutines against a handful of
+// kind/path keys, the way concurrent DBClients sharing a registry entry and a
+// Reopen call racing them would. Run with -race to catch any TOCTOU window
+// between dropping the registry lock and acting on an entry.
+func TestDBRegistry_ConcurrentAcquireReleaseForceClose(t *testing.T) {
+	const (
+		workers    = 32
+		iterations = 200
+		numKeys    = 4
+	)
+
+	keys := make([]dbRegistryKey, numKeys)
+	for i := range keys {
+		keys[i] = dbRegistryKey{kind: "concurrent-test", path: fmt.Sprintf("%s-%d", t.Name(), i)}
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(seed int) {
+			defer wg.Done()
+			for i := 0; i < iterations; i++ {
+				key := keys[(seed+i)%numKeys]
+				if _, locked, err := acquireDB(key.kind, key.path, func() (*fakeDBDriver, bool, error) {
+					return &fakeDBDriver{}, false, nil
+				}); err != nil || locked {
+					t.Errorf("acquireDB: locked=%v err=%v", locked, err)
+					return
+				}
+
+				var opErr error
+				if i%3 == 1 {
+					_, opErr = forceCloseDB(key.kind, key.path)
+				} else {
+					opErr = releaseDB(key.kind, key.path)
+				}
+				if opErr != nil {
+					t.Errorf("release/forceClose: %v", opErr)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	// Drain whatever refs are left so the registry doesn't leak into other
+	// tests in this package.
+	for _, key := range keys {
+		if _, err := forceCloseDB(key.kind, key.path); err != nil {
+			t.Errorf("cleanup forceCloseDB: %v", err)
+		}
+	}
+}
+
+// TestRollbackAcquiredRefs_ReleasesEveryAcquiredRef exercises the helper
+// NewDBClient uses when a later DB in the same call turns out to be locked:
+// every ref acquired before that point must be released, not left stuck at
+// refCount>=1 with no DBClient able to reach it.
+func TestRollbackAcquiredRefs_ReleasesEveryAcquiredRef(t *testing.T) {
+	open := func() (*fakeDBDriver, bool, error) { return &fakeDBDriver{}, false, nil }
+
+	refs := []dbRef{
+		{kind: "test-rollback-cve", path: t.Name() + "-cve"},
+		{kind: "test-rollback-oval", path: t.Name() + "-oval"},
+	}
+	drivers := make([]*fakeDBDriver, len(refs))
+	for i, ref := range refs {
+		d, _, err := acquireDB(ref.kind, ref.path, open)
+		if err != nil {
+			t.Fatalf("acquireDB %s: %v", ref.kind, err)
+		}
+		drivers[i] = d
+	}
+
+	rollbackAcquiredRefs(refs)
+
+	for i, ref := range refs {
+		if !drivers[i].closed {
+			t.Errorf("rollbackAcquiredRefs did not close %s driver", ref.kind)
+		}
+		if _, ok := dbRegistry[dbRegistryKey{kind: ref.kind, path: ref.path}]; ok {
+			t.Errorf("rollbackAcquiredRefs left %s entry in the registry", ref.kind)
+		}
+	}
+}
@@ -1,18 +1,35 @@
 package reporter
 
 import (
-	"bytes"
+	"bufio"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
 	"encoding/json"
 	"fmt"
+	"io"
+	"os"
 	"time"
 
-	storage "github.com/Azure/azure-sdk-for-go/storage"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
 	"golang.org/x/xerrors"
 
 	c "github.com/future-architect/vuls/config"
 	"github.com/future-architect/vuls/models"
 )
 
+// defaultBlockSize and defaultConcurrency are used when AzureBlobWriter
+// doesn't specify its own BlockSize/Concurrency.
+const (
+	defaultBlockSize   = 4 * 1024 * 1024
+	defaultConcurrency = 3
+)
+
 // AzureBlobWriter writes results to AzureBlob
 type AzureBlobWriter struct {
 	FormatJSON        bool
@@ -20,6 +37,13 @@ type AzureBlobWriter struct {
 	FormatOneLineText bool
 	FormatList        bool
 	Gzip              bool
+
+	// BlockSize is the size in bytes of each block staged by UploadStream.
+	// Zero means defaultBlockSize.
+	BlockSize int64
+	// Concurrency is the number of blocks staged in parallel by UploadStream.
+	// Zero means defaultConcurrency.
+	Concurrency int
 }
 
 // Write results to Azure Blob storage
@@ -37,37 +61,44 @@ func (w AzureBlobWriter) Write(rs ...models.ScanResult) (err error) {
 		timestr := rs[0].ScannedAt.Format(time.RFC3339)
 		k := fmt.Sprintf(timestr + "/summary.txt")
 		text := formatOneLineSummary(rs...)
-		b := []byte(text)
-		if err := createBlockBlob(cli, k, b, w.Gzip); err != nil {
+		if err := w.createBlockBlob(cli, k, func(dst io.Writer) error {
+			_, err := io.WriteString(dst, text)
+			return err
+		}); err != nil {
 			return err
 		}
 	}
 
 	for _, r := range rs {
+		r := r
 		key := r.ReportKeyName()
 		if w.FormatJSON {
 			k := key + ".json"
-			var b []byte
-			if b, err = json.Marshal(r); err != nil {
-				return xerrors.Errorf("Failed to Marshal to JSON: %w", err)
-			}
-			if err := createBlockBlob(cli, k, b, w.Gzip); err != nil {
+			if err := w.createBlockBlob(cli, k, func(dst io.Writer) error {
+				return json.NewEncoder(dst).Encode(r)
+			}); err != nil {
 				return err
 			}
 		}
 
 		if w.FormatList {
 			k := key + "_short.txt"
-			b := []byte(formatList(r))
-			if err := createBlockBlob(cli, k, b, w.Gzip); err != nil {
+			text := formatList(r)
+			if err := w.createBlockBlob(cli, k, func(dst io.Writer) error {
+				_, err := io.WriteString(dst, text)
+				return err
+			}); err != nil {
 				return err
 			}
 		}
 
 		if w.FormatFullText {
 			k := key + "_full.txt"
-			b := []byte(formatFullPlainText(r))
-			if err := createBlockBlob(cli, k, b, w.Gzip); err != nil {
+			text := formatFullPlainText(r)
+			if err := w.createBlockBlob(cli, k, func(dst io.Writer) error {
+				_, err := io.WriteString(dst, text)
+				return err
+			}); err != nil {
 				return err
 			}
 		}
@@ -81,46 +112,216 @@ func CheckIfAzureContainerExists() error {
 	if err != nil {
 		return err
 	}
-	r, err := cli.ListContainers(storage.ListContainersParameters{})
+	_, err = cli.ServiceClient().NewContainerClient(c.Conf.Azure.ContainerName).GetProperties(context.Background(), nil)
 	if err != nil {
-		return err
+		return xerrors.Errorf("Container not found. Container: %s, err: %w", c.Conf.Azure.ContainerName, err)
+	}
+	return nil
+}
+
+// cloudConfiguration returns the azcore cloud.Configuration that matches config.Conf.Azure.CloudEnvironment
+func cloudConfiguration() cloud.Configuration {
+	switch c.Conf.Azure.CloudEnvironment {
+	case "AzureChina":
+		return cloud.AzureChina
+	case "AzureGovernment":
+		return cloud.AzureGovernment
+	default:
+		return cloud.AzurePublic
+	}
+}
+
+// getBlobClient builds an *azblob.Client from config.Conf.Azure, choosing among
+// a shared account key, a SAS token, a connection string, a service principal
+// (client secret or client certificate) or a DefaultAzureCredential chain.
+func getBlobClient() (*azblob.Client, error) {
+	azCnf := c.Conf.Azure
+	clientOpts := &azblob.ClientOptions{
+		ClientOptions: azcoreClientOptions(cloudConfiguration()),
 	}
 
-	found := false
-	for _, con := range r.Containers {
-		if con.Name == c.Conf.Azure.ContainerName {
-			found = true
-			break
+	switch {
+	case azCnf.ConnectionString != "":
+		cli, err := azblob.NewClientFromConnectionString(azCnf.ConnectionString, clientOpts)
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to create blob client from connection string: %w", err)
 		}
+		return cli, nil
+
+	case azCnf.AccountKey != "":
+		cred, err := azblob.NewSharedKeyCredential(azCnf.AccountName, azCnf.AccountKey)
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to create shared key credential: %w", err)
+		}
+		cli, err := azblob.NewClientWithSharedKeyCredential(serviceURL(azCnf.AccountName), cred, clientOpts)
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to create blob client with shared key: %w", err)
+		}
+		return cli, nil
+
+	case azCnf.SASToken != "":
+		cli, err := azblob.NewClientWithNoCredential(serviceURL(azCnf.AccountName)+"?"+azCnf.SASToken, clientOpts)
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to create blob client with SAS token: %w", err)
+		}
+		return cli, nil
+
+	default:
+		cred, err := azureTokenCredential(azCnf)
+		if err != nil {
+			return nil, err
+		}
+		cli, err := azblob.NewClient(serviceURL(azCnf.AccountName), cred, clientOpts)
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to create blob client: %w", err)
+		}
+		return cli, nil
 	}
-	if !found {
-		return xerrors.Errorf("Container not found. Container: %s", c.Conf.Azure.ContainerName)
+}
+
+// azureTokenCredential selects a Service Principal (client secret or client
+// certificate) or a Managed Identity, depending on what config.Conf.Azure
+// carries, and otherwise falls back to DefaultAzureCredential. There is no
+// Workload Identity branch of its own; a Workload Identity is only picked up
+// through the DefaultAzureCredential chain.
+func azureTokenCredential(azCnf c.AzureConf) (azcore.TokenCredential, error) {
+	opts := &azidentity.DefaultAzureCredentialOptions{
+		ClientOptions: azcoreClientOptions(cloudConfiguration()),
+	}
+
+	switch {
+	case azCnf.ClientID != "" && azCnf.ClientSecret != "" && azCnf.TenantID != "":
+		cred, err := azidentity.NewClientSecretCredential(azCnf.TenantID, azCnf.ClientID, azCnf.ClientSecret,
+			&azidentity.ClientSecretCredentialOptions{ClientOptions: opts.ClientOptions})
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to create client secret credential: %w", err)
+		}
+		return cred, nil
+
+	case azCnf.ClientID != "" && azCnf.ClientCertificatePath != "" && azCnf.TenantID != "":
+		data, err := os.ReadFile(azCnf.ClientCertificatePath)
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to read client certificate %s: %w", azCnf.ClientCertificatePath, err)
+		}
+		certs, key, err := azidentity.ParseCertificates(data, []byte(azCnf.ClientCertificatePassword))
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to parse client certificate: %w", err)
+		}
+		cred, err := azidentity.NewClientCertificateCredential(azCnf.TenantID, azCnf.ClientID, certs, key,
+			&azidentity.ClientCertificateCredentialOptions{ClientOptions: opts.ClientOptions})
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to create client certificate credential: %w", err)
+		}
+		return cred, nil
+
+	case azCnf.ManagedIdentityClientID != "":
+		cred, err := azidentity.NewManagedIdentityCredential(&azidentity.ManagedIdentityCredentialOptions{
+			ID:            azidentity.ClientID(azCnf.ManagedIdentityClientID),
+			ClientOptions: opts.ClientOptions,
+		})
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to create managed identity credential: %w", err)
+		}
+		return cred, nil
+
+	default:
+		cred, err := azidentity.NewDefaultAzureCredential(opts)
+		if err != nil {
+			return nil, xerrors.Errorf("Failed to create default azure credential: %w", err)
+		}
+		return cred, nil
 	}
-	return nil
 }
 
-func getBlobClient() (storage.BlobStorageClient, error) {
-	api, err := storage.NewBasicClient(c.Conf.Azure.AccountName, c.Conf.Azure.AccountKey)
-	if err != nil {
-		return storage.BlobStorageClient{}, err
+// azcoreClientOptions returns the azcore.ClientOptions pinned to the given cloud
+func azcoreClientOptions(cloudCnf cloud.Configuration) azcore.ClientOptions {
+	return azcore.ClientOptions{Cloud: cloudCnf}
+}
+
+// blobEndpointSuffix returns the blob-storage hostname suffix for config.Conf.Azure.CloudEnvironment
+func blobEndpointSuffix() string {
+	switch c.Conf.Azure.CloudEnvironment {
+	case "AzureChina":
+		return "blob.core.chinacloudapi.cn"
+	case "AzureGovernment":
+		return "blob.core.usgovcloudapi.net"
+	default:
+		return "blob.core.windows.net"
 	}
-	return api.GetBlobService(), nil
 }
 
-func createBlockBlob(cli storage.BlobStorageClient, k string, b []byte, gzip bool) error {
-	var err error
-	if gzip {
-		if b, err = gz(b); err != nil {
-			return err
-		}
+func serviceURL(accountName string) string {
+	return fmt.Sprintf("https://%s.%s/", accountName, blobEndpointSuffix())
+}
+
+// createBlockBlob streams the bytes written by encode to a block blob named k,
+// optionally gzip-compressing them on the fly, without buffering the whole
+// payload in memory. Content-MD5 and Content-Encoding are set on the blob
+// after the upload completes, once the digest of the uploaded bytes is known.
+func (w AzureBlobWriter) createBlockBlob(cli *azblob.Client, k string, encode func(io.Writer) error) error {
+	if w.Gzip {
 		k += ".gz"
 	}
 
-	ref := cli.GetContainerReference(c.Conf.Azure.ContainerName)
-	blob := ref.GetBlobReference(k)
-	if err := blob.CreateBlockBlobFromReader(bytes.NewReader(b), nil); err != nil {
+	pr, pw := io.Pipe()
+	digest := md5.New()
+
+	go func() {
+		dst := io.Writer(io.MultiWriter(pw, digest))
+		var gzw *gzip.Writer
+		if w.Gzip {
+			gzw = gzip.NewWriter(dst)
+			dst = gzw
+		}
+
+		bufSize := c.Conf.Azure.BufferSize
+		if bufSize <= 0 {
+			bufSize = defaultBlockSize
+		}
+		buf := bufio.NewWriterSize(dst, bufSize)
+
+		err := encode(buf)
+		if err == nil {
+			err = buf.Flush()
+		}
+		if gzw != nil {
+			if cerr := gzw.Close(); err == nil {
+				err = cerr
+			}
+		}
+		pw.CloseWithError(err)
+	}()
+
+	blockSize := w.BlockSize
+	if blockSize <= 0 {
+		blockSize = defaultBlockSize
+	}
+	concurrency := w.Concurrency
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+
+	_, err := cli.UploadStream(context.Background(), c.Conf.Azure.ContainerName, k, pr, &azblob.UploadStreamOptions{
+		BlockSize:   blockSize,
+		Concurrency: concurrency,
+	})
+	// UploadStream may return before draining pr to EOF (network error, auth
+	// failure, a rejected block); CloseWithError unblocks the producer
+	// goroutine above, which would otherwise hang forever on pw.Write/gzw.Close.
+	pr.CloseWithError(err)
+	if err != nil {
 		return xerrors.Errorf("Failed to upload data to %s/%s, err: %w",
 			c.Conf.Azure.ContainerName, k, err)
 	}
+
+	headers := blob.HTTPHeaders{BlobContentMD5: digest.Sum(nil)}
+	if w.Gzip {
+		headers.BlobContentEncoding = to.Ptr("gzip")
+	}
+	blobCli := cli.ServiceClient().NewContainerClient(c.Conf.Azure.ContainerName).NewBlockBlobClient(k)
+	if _, err := blobCli.SetHTTPHeaders(context.Background(), headers, nil); err != nil {
+		return xerrors.Errorf("Failed to set blob properties on %s/%s, err: %w",
+			c.Conf.Azure.ContainerName, k, err)
+	}
 	return nil
 }
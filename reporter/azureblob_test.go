@@ -0,0 +1,270 @@
+package reporter
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+
+	c "github.com/future-architect/vuls/config"
+)
+
+func TestCloudConfigurationAndEndpoints(t *testing.T) {
+	orig := c.Conf.Azure.CloudEnvironment
+	defer func() { c.Conf.Azure.CloudEnvironment = orig }()
+
+	tests := []struct {
+		env        string
+		wantCloud  cloud.Configuration
+		wantSuffix string
+	}{
+		{"", cloud.AzurePublic, "blob.core.windows.net"},
+		{"AzureChina", cloud.AzureChina, "blob.core.chinacloudapi.cn"},
+		{"AzureGovernment", cloud.AzureGovernment, "blob.core.usgovcloudapi.net"},
+		{"bogus", cloud.AzurePublic, "blob.core.windows.net"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.env, func(t *testing.T) {
+			c.Conf.Azure.CloudEnvironment = tt.env
+
+			if got := cloudConfiguration(); !reflect.DeepEqual(got, tt.wantCloud) {
+				t.Errorf("cloudConfiguration() = %+v, want %+v", got, tt.wantCloud)
+			}
+			if got := blobEndpointSuffix(); got != tt.wantSuffix {
+				t.Errorf("blobEndpointSuffix() = %q, want %q", got, tt.wantSuffix)
+			}
+			want := "https://myaccount." + tt.wantSuffix + "/"
+			if got := serviceURL("myaccount"); got != want {
+				t.Errorf("serviceURL() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+// TestAzureTokenCredential_SelectsExpectedCredentialType pins down which
+// azidentity credential each combination of config.Conf.Azure fields
+// routes to. The client-certificate case in particular asserts that the
+// certificate file is actually read from ClientCertificatePath via
+// os.ReadFile, so a missing file surfaces as a "no such file" error rather
+// than silently falling through to a different credential type.
+func TestAzureTokenCredential_SelectsExpectedCredentialType(t *testing.T) {
+	tests := []struct {
+		name      string
+		cnf       c.AzureConf
+		wantErr   bool
+		wantErrIs error
+		check     func(t *testing.T, cred azcore.TokenCredential)
+	}{
+		{
+			name: "client secret credential",
+			cnf:  c.AzureConf{ClientID: "cid", ClientSecret: "secret", TenantID: "tid"},
+			check: func(t *testing.T, cred azcore.TokenCredential) {
+				if _, ok := cred.(*azidentity.ClientSecretCredential); !ok {
+					t.Fatalf("got %T, want *azidentity.ClientSecretCredential", cred)
+				}
+			},
+		},
+		{
+			name:    "client certificate credential reads the cert file",
+			cnf:     c.AzureConf{ClientID: "cid", TenantID: "tid", ClientCertificatePath: "/nonexistent/cert.pem", ClientCertificatePassword: "not-a-path"},
+			wantErr:   true,
+			wantErrIs: fs.ErrNotExist,
+		},
+		{
+			name: "managed identity credential",
+			cnf:  c.AzureConf{ManagedIdentityClientID: "mi-client-id"},
+			check: func(t *testing.T, cred azcore.TokenCredential) {
+				if _, ok := cred.(*azidentity.ManagedIdentityCredential); !ok {
+					t.Fatalf("got %T, want *azidentity.ManagedIdentityCredential", cred)
+				}
+			},
+		},
+		{
+			name: "falls back to DefaultAzureCredential",
+			cnf:  c.AzureConf{},
+			check: func(t *testing.T, cred azcore.TokenCredential) {
+				if _, ok := cred.(*azidentity.DefaultAzureCredential); !ok {
+					t.Fatalf("got %T, want *azidentity.DefaultAzureCredential", cred)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred, err := azureTokenCredential(tt.cnf)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("azureTokenCredential(%+v) succeeded, want error", tt.cnf)
+				}
+				if tt.wantErrIs != nil && !errors.Is(err, tt.wantErrIs) {
+					t.Fatalf("azureTokenCredential(%+v) error = %v, want errors.Is %v", tt.cnf, err, tt.wantErrIs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("azureTokenCredential(%+v): %v", tt.cnf, err)
+			}
+			tt.check(t, cred)
+		})
+	}
+}
+
+// fakeBlockBlobServer is a minimal stand-in for the Put Block / Put Block
+// List / Set Blob Properties calls createBlockBlob makes, just enough to
+// reconstruct the bytes it actually uploaded and the blob properties it set.
+type fakeBlockBlobServer struct {
+	mu              sync.Mutex
+	blocks          map[string][]byte
+	assembled       []byte
+	contentMD5      string
+	contentEncoding string
+}
+
+func newFakeBlockBlobServer() (*httptest.Server, *fakeBlockBlobServer) {
+	state := &fakeBlockBlobServer{blocks: map[string][]byte{}}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"etag"`)
+		switch r.URL.Query().Get("comp") {
+		case "block":
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			state.mu.Lock()
+			state.blocks[r.URL.Query().Get("blockid")] = body
+			state.mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		case "blocklist":
+			body, err := io.ReadAll(r.Body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			ids, err := committedBlockIDsInOrder(body)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			state.mu.Lock()
+			var assembled bytes.Buffer
+			for _, id := range ids {
+				assembled.Write(state.blocks[id])
+			}
+			state.assembled = assembled.Bytes()
+			state.mu.Unlock()
+			w.WriteHeader(http.StatusCreated)
+		case "properties":
+			if r.Method == http.MethodPut {
+				state.mu.Lock()
+				state.contentMD5 = r.Header.Get("x-ms-blob-content-md5")
+				state.contentEncoding = r.Header.Get("x-ms-blob-content-encoding")
+				state.mu.Unlock()
+			}
+			w.WriteHeader(http.StatusOK)
+		default:
+			w.WriteHeader(http.StatusOK)
+		}
+	}))
+	return srv, state
+}
+
+// committedBlockIDsInOrder returns the block IDs listed in a Put Block List
+// request body, in document order, regardless of whether the SDK tagged them
+// Uncommitted/Latest/Committed.
+func committedBlockIDsInOrder(body []byte) ([]string, error) {
+	dec := xml.NewDecoder(bytes.NewReader(body))
+	var ids []string
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return ids, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local == "BlockList" {
+			continue
+		}
+		var id string
+		if err := dec.DecodeElement(&id, &se); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+}
+
+// TestCreateBlockBlob_StreamsGzipAndMD5 drives createBlockBlob against a fake
+// block-blob server and checks that the bytes it actually staged, once
+// reassembled in commit order, are the gzip-compressed payload whose MD5 it
+// then set as the blob's Content-MD5.
+func TestCreateBlockBlob_StreamsGzipAndMD5(t *testing.T) {
+	srv, state := newFakeBlockBlobServer()
+	defer srv.Close()
+
+	origContainer, origBufSize := c.Conf.Azure.ContainerName, c.Conf.Azure.BufferSize
+	c.Conf.Azure.ContainerName = "test-container"
+	c.Conf.Azure.BufferSize = 0
+	defer func() {
+		c.Conf.Azure.ContainerName = origContainer
+		c.Conf.Azure.BufferSize = origBufSize
+	}()
+
+	cli, err := azblob.NewClientWithNoCredential(srv.URL+"/", &azblob.ClientOptions{
+		ClientOptions: azcore.ClientOptions{Transport: srv.Client()},
+	})
+	if err != nil {
+		t.Fatalf("azblob.NewClientWithNoCredential: %v", err)
+	}
+
+	w := AzureBlobWriter{Gzip: true, BlockSize: 16}
+	const payload = "the quick brown fox jumps over the lazy dog, repeated so it spans multiple 16-byte blocks"
+	if err := w.createBlockBlob(cli, "some/key", func(dst io.Writer) error {
+		_, err := io.WriteString(dst, payload)
+		return err
+	}); err != nil {
+		t.Fatalf("createBlockBlob: %v", err)
+	}
+
+	state.mu.Lock()
+	assembled, contentMD5, contentEncoding := state.assembled, state.contentMD5, state.contentEncoding
+	state.mu.Unlock()
+
+	if contentEncoding != "gzip" {
+		t.Errorf("Content-Encoding = %q, want %q", contentEncoding, "gzip")
+	}
+
+	sum := md5.Sum(assembled)
+	wantMD5 := base64.StdEncoding.EncodeToString(sum[:])
+	if contentMD5 != wantMD5 {
+		t.Errorf("Content-MD5 = %q, want %q (md5 of the %d bytes actually staged)", contentMD5, wantMD5, len(assembled))
+	}
+
+	gzr, err := gzip.NewReader(bytes.NewReader(assembled))
+	if err != nil {
+		t.Fatalf("uploaded bytes are not valid gzip: %v", err)
+	}
+	got, err := io.ReadAll(gzr)
+	if err != nil {
+		t.Fatalf("reading gzip stream: %v", err)
+	}
+	if string(got) != payload {
+		t.Errorf("decompressed upload = %q, want %q", got, payload)
+	}
+}